@@ -0,0 +1,14 @@
+package coords
+
+// GetTileFromMapBlock returns the zoom-13 (mapblock-level) tile that covers
+// the X/Z column containing mapblock pos, for the given layer. Mapblock Y
+// (height) is ignored, since a layer's vertical slice is selected
+// separately via layer.Layer.From/To.
+func GetTileFromMapBlock(layerId int, pos MapBlockCoords) *TileCoords {
+	return &TileCoords{
+		LayerId: layerId,
+		Zoom:    13,
+		X:       pos.X - MinCoord,
+		Y:       pos.Z - MinCoord,
+	}
+}
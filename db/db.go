@@ -0,0 +1,17 @@
+package db
+
+import "mapserver/coords"
+
+// BlockChange describes a single world mapblock that was written.
+type BlockChange struct {
+	Pos coords.MapBlockCoords
+}
+
+// DBAccessor reads world data and, where the backing store supports it,
+// notifies callers of changed mapblocks.
+type DBAccessor interface {
+	// Subscribe returns a channel of mapblocks that changed after
+	// Subscribe was called. The channel is closed when the accessor is
+	// closed.
+	Subscribe() <-chan BlockChange
+}
@@ -0,0 +1,31 @@
+package layer
+
+// Format selects the tile encoding a Layer is rendered and cached as.
+type Format string
+
+const (
+	FormatPNG  Format = "png"
+	FormatMVT  Format = "mvt"
+	FormatWebP Format = "webp"
+	FormatJPEG Format = "jpeg"
+)
+
+// DefaultQuality is used when a Layer doesn't set Quality, matching a
+// typical JPEG/WebP "high quality" preset.
+const DefaultQuality = 85
+
+// Layer describes a renderable map layer: its identity, the vertical slice
+// of the world it covers, and the tile format it should be served as.
+type Layer struct {
+	Id   int
+	From int
+	To   int
+
+	// Format is the tile encoding used for this layer, defaulting to
+	// FormatPNG when empty.
+	Format Format
+
+	// Quality is the JPEG/WebP encoder quality (1-100), ignored for PNG
+	// and MVT. Defaults to DefaultQuality when zero.
+	Quality int
+}
@@ -0,0 +1,117 @@
+package tiledb
+
+import (
+	"container/list"
+	"sync"
+
+	"mapserver/coords"
+)
+
+// Stats reports CachingAccessor hit/miss counters so operators can tune
+// cache sizing.
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+type cacheEntry struct {
+	key  coords.TileCoords
+	tile *Tile
+}
+
+// CachingAccessor wraps any DBAccessor with an in-memory LRU, keyed by
+// coords.TileCoords, so hot overview tiles avoid a disk or SQLite
+// round-trip on every request.
+type CachingAccessor struct {
+	next     DBAccessor
+	maxBytes int64
+
+	mu      sync.Mutex
+	bytes   int64
+	order   *list.List
+	entries map[coords.TileCoords]*list.Element
+	hits    uint64
+	misses  uint64
+}
+
+// NewCachingAccessor wraps next with an LRU capped at maxMiB mebibytes of
+// tile data.
+func NewCachingAccessor(next DBAccessor, maxMiB int) *CachingAccessor {
+	return &CachingAccessor{
+		next:     next,
+		maxBytes: int64(maxMiB) * 1024 * 1024,
+		order:    list.New(),
+		entries:  make(map[coords.TileCoords]*list.Element),
+	}
+}
+
+func (c *CachingAccessor) GetTile(tc *coords.TileCoords) (*Tile, error) {
+	c.mu.Lock()
+	if el, ok := c.entries[*tc]; ok {
+		c.order.MoveToFront(el)
+		tile := el.Value.(*cacheEntry).tile
+		c.hits++
+		c.mu.Unlock()
+		return tile, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	tile, err := c.next.GetTile(tc)
+	if err != nil || tile == nil {
+		return tile, err
+	}
+
+	c.put(*tc, tile)
+	return tile, nil
+}
+
+func (c *CachingAccessor) SetTile(tile *Tile) error {
+	if err := c.next.SetTile(tile); err != nil {
+		return err
+	}
+
+	c.put(*tile.Pos, tile)
+	return nil
+}
+
+func (c *CachingAccessor) MarkDirty(tc *coords.TileCoords) error {
+	c.mu.Lock()
+	if el, ok := c.entries[*tc]; ok {
+		el.Value.(*cacheEntry).tile.Dirty = true
+	}
+	c.mu.Unlock()
+
+	return c.next.MarkDirty(tc)
+}
+
+// Stats returns the current hit/miss counters.
+func (c *CachingAccessor) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{Hits: c.hits, Misses: c.misses}
+}
+
+func (c *CachingAccessor) put(key coords.TileCoords, tile *Tile) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		c.bytes -= int64(len(entry.tile.Data))
+		entry.tile = tile
+		c.order.MoveToFront(el)
+	} else {
+		el := c.order.PushFront(&cacheEntry{key: key, tile: tile})
+		c.entries[key] = el
+	}
+	c.bytes += int64(len(tile.Data))
+
+	for c.bytes > c.maxBytes && c.order.Len() > 0 {
+		back := c.order.Back()
+		entry := back.Value.(*cacheEntry)
+		c.bytes -= int64(len(entry.tile.Data))
+		delete(c.entries, entry.key)
+		c.order.Remove(back)
+	}
+}
@@ -0,0 +1,143 @@
+package tiledb
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"mapserver/coords"
+	"mapserver/layer"
+)
+
+// fsDefaultExt is the file extension used when a Tile has no Format set,
+// matching the renderer's PNG default.
+const fsDefaultExt = "png"
+
+// FSAccessor stores tiles directly on disk as
+// {root}/{layerId}/{zoom}/{x}/{y}.{ext}, where {ext} matches the tile's
+// Format (defaulting to "png"), using the file's own mtime for Tile.Mtime
+// and a {y}.{ext}.dirty sidecar file for the dirty flag. It needs no
+// separate database, making it a simple alternative to a SQLite-backed
+// DBAccessor for small deployments or read-only snapshots.
+//
+// Because the format is encoded in the file extension, a tile that changes
+// format (e.g. a layer's Format config changes from png to webp) is written
+// to a new file; SetTile then removes the tile's files in the other formats.
+type FSAccessor struct {
+	root string
+}
+
+// NewFSAccessor creates an FSAccessor rooted at root, creating it (and any
+// missing parents) if it doesn't already exist.
+func NewFSAccessor(root string) (*FSAccessor, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &FSAccessor{root: root}, nil
+}
+
+func (a *FSAccessor) GetTile(tc *coords.TileCoords) (*Tile, error) {
+	for _, format := range fsCandidateFormats {
+		path := a.tilePath(tc, format)
+
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		_, dirtyErr := os.Stat(path + ".dirty")
+		dirty := dirtyErr == nil
+
+		return &Tile{Pos: tc, Data: data, Mtime: info.ModTime().Unix(), Format: string(format), Dirty: dirty}, nil
+	}
+
+	return nil, nil
+}
+
+func (a *FSAccessor) SetTile(tile *Tile) error {
+	format := fsFormat(tile.Format)
+	path := a.tilePath(tile.Pos, format)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, tile.Data, 0644); err != nil {
+		return err
+	}
+
+	// A fresh render clears any previous dirty marker.
+	os.Remove(path + ".dirty")
+
+	// Clear stale files left over from a previous format at this tile, so
+	// GetTile's format scan doesn't resurrect them.
+	for _, other := range fsCandidateFormats {
+		if other == format {
+			continue
+		}
+		otherPath := a.tilePath(tile.Pos, other)
+		os.Remove(otherPath)
+		os.Remove(otherPath + ".dirty")
+	}
+
+	return nil
+}
+
+func (a *FSAccessor) MarkDirty(tc *coords.TileCoords) error {
+	tile, err := a.GetTile(tc)
+	if err != nil {
+		return err
+	}
+
+	format := layer.Format(fsDefaultExt)
+	if tile != nil {
+		format = fsFormat(tile.Format)
+	}
+
+	path := a.tilePath(tc, format) + ".dirty"
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (a *FSAccessor) tilePath(tc *coords.TileCoords, format layer.Format) string {
+	ext := string(format)
+	if ext == "" {
+		ext = fsDefaultExt
+	}
+
+	return filepath.Join(
+		a.root,
+		strconv.Itoa(tc.LayerId),
+		strconv.Itoa(tc.Zoom),
+		strconv.Itoa(tc.X),
+		strconv.Itoa(tc.Y)+"."+ext,
+	)
+}
+
+// fsCandidateFormats is the set of extensions GetTile probes for, since the
+// accessor doesn't otherwise know which format a given tile was last stored
+// as.
+var fsCandidateFormats = []layer.Format{layer.FormatPNG, layer.FormatWebP, layer.FormatJPEG, layer.FormatMVT}
+
+// fsFormat normalizes an empty Tile.Format to the PNG default.
+func fsFormat(format string) layer.Format {
+	if format == "" {
+		return layer.FormatPNG
+	}
+	return layer.Format(format)
+}
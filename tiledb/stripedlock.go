@@ -0,0 +1,85 @@
+package tiledb
+
+import (
+	"sync"
+
+	"mapserver/coords"
+)
+
+// StripedLockAccessor wraps a DBAccessor and serializes, per tile, the
+// whole "check cache, render on miss, store" sequence a caller runs around
+// it - not just the final SetTile - via WithLock. The name is kept for
+// compatibility with existing callers, but each tile gets its own
+// dynamically-created lock rather than a fixed hash bucket: two different
+// tiles never contend for the same lock, so a caller can safely hold a
+// parent tile's lock for its whole render while recursing into children,
+// each of which takes out its own independent lock.
+type StripedLockAccessor struct {
+	next DBAccessor
+
+	mu    sync.Mutex
+	locks map[coords.TileCoords]*tileLock
+}
+
+// tileLock is a per-tile mutex, reference-counted so StripedLockAccessor
+// can drop it from the map once nobody is waiting on it.
+type tileLock struct {
+	mu       sync.Mutex
+	refCount int
+}
+
+// NewStripedLockAccessor wraps next so callers can serialize a full
+// fetch-or-render sequence per tile via WithLock.
+func NewStripedLockAccessor(next DBAccessor) *StripedLockAccessor {
+	return &StripedLockAccessor{next: next, locks: make(map[coords.TileCoords]*tileLock)}
+}
+
+func (a *StripedLockAccessor) GetTile(tc *coords.TileCoords) (*Tile, error) {
+	return a.next.GetTile(tc)
+}
+
+func (a *StripedLockAccessor) SetTile(tile *Tile) error {
+	return a.next.SetTile(tile)
+}
+
+func (a *StripedLockAccessor) MarkDirty(tc *coords.TileCoords) error {
+	return a.next.MarkDirty(tc)
+}
+
+// WithLock runs fn while holding tc's per-tile lock, so two concurrent
+// callers for the same tile can't both pass a cache-miss check and
+// redundantly render it - the second blocks until the first finishes and
+// its store (if any) becomes visible.
+func (a *StripedLockAccessor) WithLock(tc *coords.TileCoords, fn func() error) error {
+	lock := a.lockFor(*tc)
+	defer a.unlockAndRelease(*tc, lock)
+
+	lock.mu.Lock()
+	return fn()
+}
+
+func (a *StripedLockAccessor) lockFor(key coords.TileCoords) *tileLock {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	l, ok := a.locks[key]
+	if !ok {
+		l = &tileLock{}
+		a.locks[key] = l
+	}
+	l.refCount++
+
+	return l
+}
+
+func (a *StripedLockAccessor) unlockAndRelease(key coords.TileCoords, l *tileLock) {
+	l.mu.Unlock()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	l.refCount--
+	if l.refCount == 0 {
+		delete(a.locks, key)
+	}
+}
@@ -0,0 +1,26 @@
+package tiledb
+
+import "mapserver/coords"
+
+// Tile is a single cached, encoded tile. Format records the encoding Data
+// was stored as, so a cache hit can be returned verbatim without
+// re-encoding when it matches the requested format. Dirty is set by a
+// tileinvalidator when the underlying world data has changed since Data
+// was rendered.
+type Tile struct {
+	Pos    *coords.TileCoords
+	Data   []byte
+	Mtime  int64
+	Format string
+	Dirty  bool
+}
+
+// DBAccessor persists rendered tiles keyed by their coordinates.
+type DBAccessor interface {
+	GetTile(tc *coords.TileCoords) (*Tile, error)
+	SetTile(tile *Tile) error
+
+	// MarkDirty flags a cached tile as stale without evicting it, so
+	// TileRenderer.Render can still fall back to it on a render error.
+	MarkDirty(tc *coords.TileCoords) error
+}
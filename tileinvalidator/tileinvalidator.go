@@ -0,0 +1,180 @@
+package tileinvalidator
+
+import (
+	"mapserver/coords"
+	"mapserver/db"
+	"mapserver/tiledb"
+	"mapserver/tilerenderer"
+
+	"github.com/sirupsen/logrus"
+)
+
+var log = logrus.WithField("module", "tileinvalidator")
+
+const (
+	quadUL uint8 = 1 << iota
+	quadUR
+	quadLL
+	quadLR
+
+	quadAll = quadUL | quadUR | quadLL | quadLR
+)
+
+// Invalidator subscribes to world block-change notifications and keeps
+// tiledb's cached tiles in sync: it marks the whole ancestor chain of a
+// changed mapblock dirty immediately, then uses a bounded worker pool to
+// proactively re-render dirty base tiles and bubble the regeneration up to
+// parent zooms once all four of a parent's quadrant children are clean.
+type Invalidator struct {
+	dba      db.DBAccessor
+	tdb      tiledb.DBAccessor
+	renderer *tilerenderer.TileRenderer
+	layerIds []int
+	workers  int
+
+	jobs chan coords.TileCoords
+}
+
+// NewInvalidator creates an Invalidator that watches dba for changes and
+// keeps tdb's tiles for layerIds fresh using workers goroutines.
+func NewInvalidator(dba db.DBAccessor, tdb tiledb.DBAccessor, renderer *tilerenderer.TileRenderer, layerIds []int, workers int) *Invalidator {
+	return &Invalidator{
+		dba:      dba,
+		tdb:      tdb,
+		renderer: renderer,
+		layerIds: layerIds,
+		workers:  workers,
+		jobs:     make(chan coords.TileCoords, workers*4),
+	}
+}
+
+// Start launches the worker pool and begins listening for block changes.
+// It returns immediately.
+func (inv *Invalidator) Start() {
+	for i := 0; i < inv.workers; i++ {
+		go inv.worker()
+	}
+	go inv.listen()
+}
+
+func (inv *Invalidator) listen() {
+	for change := range inv.dba.Subscribe() {
+		inv.onBlockChange(change.Pos)
+	}
+}
+
+func (inv *Invalidator) onBlockChange(pos coords.MapBlockCoords) {
+	for _, layerId := range inv.layerIds {
+		base := coords.GetTileFromMapBlock(layerId, pos)
+		inv.markDirtyUpward(base)
+	}
+}
+
+// markDirtyUpward flags base and all 12 of its parent zooms as dirty in
+// tiledb, then queues the base tile for proactive re-rendering.
+func (inv *Invalidator) markDirtyUpward(base *coords.TileCoords) {
+	cur := base
+	for {
+		if err := inv.tdb.MarkDirty(cur); err != nil {
+			log.WithError(err).WithField("tile", cur).Warn("failed to mark tile dirty")
+		}
+
+		if cur.Zoom <= 1 {
+			break
+		}
+
+		cur = parentOf(cur)
+	}
+
+	inv.jobs <- *base
+}
+
+func (inv *Invalidator) worker() {
+	for tc := range inv.jobs {
+		tc := tc
+
+		// Render (unlike RenderImage) stores the result at every zoom,
+		// including 13, which clears the tile's dirty flag in tiledb.
+		if _, err := inv.renderer.Render(&tc); err != nil {
+			log.WithError(err).WithField("tile", tc).Warn("failed to re-render dirty tile")
+			continue
+		}
+
+		if tc.Zoom <= 1 {
+			continue
+		}
+
+		ready, err := inv.quadrantReady(&tc)
+		if err != nil {
+			log.WithError(err).WithField("tile", tc).Warn("failed to check sibling tiles")
+			continue
+		}
+		if ready {
+			inv.jobs <- *parentOf(&tc)
+		}
+	}
+}
+
+// quadrantReady builds tc's parent's quadrant-mask from tiledb's actual
+// state (not just which siblings this Invalidator happened to re-render in
+// the same batch) and reports whether all four of UL/UR/LL/LR are present
+// and clean. Checking ground truth this way means a single changed
+// mapblock whose siblings were already clean bubbles its parent chain up
+// immediately, instead of waiting for unrelated siblings to change too.
+func (inv *Invalidator) quadrantReady(tc *coords.TileCoords) (bool, error) {
+	var mask uint8
+
+	for _, sib := range siblingsOf(tc) {
+		sib := sib
+
+		tile, err := inv.tdb.GetTile(&sib)
+		if err != nil {
+			return false, err
+		}
+		if tile == nil || tile.Dirty {
+			continue
+		}
+
+		mask |= quadrantOf(&sib)
+	}
+
+	return mask == quadAll, nil
+}
+
+// parentOf returns tc's parent tile.
+func parentOf(tc *coords.TileCoords) *coords.TileCoords {
+	return &coords.TileCoords{
+		LayerId: tc.LayerId,
+		Zoom:    tc.Zoom - 1,
+		X:       tc.X / 2,
+		Y:       tc.Y / 2,
+	}
+}
+
+// quadrantOf reports which quadrant (UL/UR/LL/LR) tc occupies within its
+// parent.
+func quadrantOf(tc *coords.TileCoords) uint8 {
+	switch {
+	case tc.X%2 == 1 && tc.Y%2 == 0:
+		return quadUR
+	case tc.X%2 == 0 && tc.Y%2 == 1:
+		return quadLL
+	case tc.X%2 == 1 && tc.Y%2 == 1:
+		return quadLR
+	default:
+		return quadUL
+	}
+}
+
+// siblingsOf returns tc and its three quadrant siblings under the same
+// parent.
+func siblingsOf(tc *coords.TileCoords) [4]coords.TileCoords {
+	px, py := tc.X/2, tc.Y/2
+
+	return [4]coords.TileCoords{
+		{LayerId: tc.LayerId, Zoom: tc.Zoom, X: px * 2, Y: py * 2},
+		{LayerId: tc.LayerId, Zoom: tc.Zoom, X: px*2 + 1, Y: py * 2},
+		{LayerId: tc.LayerId, Zoom: tc.Zoom, X: px * 2, Y: py*2 + 1},
+		{LayerId: tc.LayerId, Zoom: tc.Zoom, X: px*2 + 1, Y: py*2 + 1},
+	}
+}
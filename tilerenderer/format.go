@@ -0,0 +1,155 @@
+package tilerenderer
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"time"
+
+	"mapserver/coords"
+	"mapserver/layer"
+	"mapserver/tiledb"
+
+	"github.com/chai2010/webp"
+)
+
+// RenderFormat is Render with an explicit output format, so callers (e.g.
+// an HTTP handler negotiating on the Accept header) can ask for something
+// other than a layer's configured default. RenderImage still composes
+// tiles losslessly as *image.NRGBA; only the outermost encode/decode here
+// is format-aware. The whole check-cache, render-on-miss, store sequence
+// for tc runs under tc's own per-tile lock (tr.locker), so two concurrent
+// callers requesting the same tile (even in different formats) can't both
+// pass the cache-miss check and redundantly render it. If a dirty tile is
+// cached and a re-render errors, the stale tile is re-encoded and served
+// rather than failing the request.
+//
+// This call doesn't take a tr.sem permit itself: a render at zoom <13
+// recurses into renderQuadrants, whose own goroutines each need a permit
+// from that same pool, so holding one here for the whole call would starve
+// them under NewTileRenderer's default capacity of 1 and deadlock forever.
+func (tr *TileRenderer) RenderFormat(tc *coords.TileCoords, format layer.Format) ([]byte, error) {
+	var data []byte
+
+	err := tr.locker.WithLock(tc, func() error {
+		var renderErr error
+		data, renderErr = tr.renderFormatLocked(tc, format)
+		return renderErr
+	})
+
+	return data, err
+}
+
+// renderFormatLocked is RenderFormat's body, assumed to already be running
+// under tc's per-tile lock, so it calls tr.renderImageLocked rather than
+// tr.RenderImage to avoid re-acquiring (and self-deadlocking on) that same
+// lock.
+func (tr *TileRenderer) renderFormatLocked(tc *coords.TileCoords, format layer.Format) ([]byte, error) {
+	// MVT isn't a raster encoding: serve it from RenderVector's path.
+	if format == layer.FormatMVT {
+		return tr.renderVectorLocked(tc)
+	}
+
+	cached, err := tr.tdb.GetTile(tc)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached != nil && !cached.Dirty && layer.Format(cached.Format) == format {
+		return cached.Data, nil
+	}
+
+	var img *image.NRGBA
+	if cached != nil && !cached.Dirty {
+		img, err = decodeTileImage(cached)
+	} else {
+		img, err = tr.renderImageLocked(tc, false)
+		if err != nil && cached != nil {
+			// Dirty but present beats an error: fall back to re-encoding
+			// the stale tile rather than failing the request outright.
+			img, err = decodeTileImage(cached)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if img == nil {
+		return nil, nil
+	}
+
+	data, err := encodeImage(img, format, tr.qualityFor(tc.LayerId))
+	if err != nil {
+		return nil, err
+	}
+
+	tr.tdb.SetTile(&tiledb.Tile{Pos: tc, Data: data, Mtime: time.Now().Unix(), Format: string(format)})
+
+	return data, nil
+}
+
+func (tr *TileRenderer) layerFor(layerId int) *layer.Layer {
+	for _, l := range tr.layers {
+		if l.Id == layerId {
+			c := l
+			return &c
+		}
+	}
+	return nil
+}
+
+func (tr *TileRenderer) defaultFormat(layerId int) layer.Format {
+	if l := tr.layerFor(layerId); l != nil && l.Format != "" {
+		return l.Format
+	}
+	return layer.FormatPNG
+}
+
+func (tr *TileRenderer) qualityFor(layerId int) int {
+	if l := tr.layerFor(layerId); l != nil && l.Quality != 0 {
+		return l.Quality
+	}
+	return layer.DefaultQuality
+}
+
+func encodeImage(img *image.NRGBA, format layer.Format, quality int) ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	switch format {
+	case layer.FormatJPEG:
+		err := jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+		return buf.Bytes(), err
+	case layer.FormatWebP:
+		err := webp.Encode(buf, img, &webp.Options{Quality: float32(quality)})
+		return buf.Bytes(), err
+	default:
+		err := png.Encode(buf, img)
+		return buf.Bytes(), err
+	}
+}
+
+func decodeTileImage(tile *tiledb.Tile) (*image.NRGBA, error) {
+	reader := bytes.NewReader(tile.Data)
+
+	var src image.Image
+	var err error
+
+	switch layer.Format(tile.Format) {
+	case layer.FormatJPEG:
+		src, err = jpeg.Decode(reader)
+	case layer.FormatWebP:
+		src, err = webp.Decode(reader)
+	default:
+		src, err = png.Decode(reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rect := image.Rect(0, 0, IMG_SIZE, IMG_SIZE)
+	img := image.NewNRGBA(rect)
+	draw.Draw(img, rect, src, image.ZP, draw.Src)
+
+	return img, nil
+}
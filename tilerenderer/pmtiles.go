@@ -0,0 +1,306 @@
+package tilerenderer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+
+	"mapserver/coords"
+	"mapserver/layer"
+)
+
+const (
+	pmtilesMagic      = "PMTiles"
+	pmtilesVersion    = 3
+	pmtilesHeaderSize = 127
+
+	// Tile type, per the PMTiles v3 spec (byte 99).
+	pmtilesTileTypePNG = 2
+
+	// Compression, per the PMTiles v3 spec (bytes 97-98). We never
+	// compress the directories/metadata/tiles we write, so both are
+	// "None" rather than "Unknown" - real readers reject Unknown.
+	pmtilesCompressionNone = 1
+
+	// pmtilesMaxDirBytes is the point at which the root directory is split
+	// into a root + leaf directories, roughly matching the reference
+	// implementation's 16KiB target.
+	pmtilesMaxDirBytes = 16384
+)
+
+// pmtilesEntry is a single (tile_id, run_length, offset, length) directory
+// entry, varint-encoded when serialized.
+type pmtilesEntry struct {
+	TileID   uint64
+	RunLength uint32
+	Offset   uint64
+	Length   uint32
+}
+
+// ExportPMTiles renders every tile of layer l between minZoom and maxZoom
+// (inclusive) and writes them to a single PMTiles v3 archive at path,
+// so the result can be served as a static, portable map snapshot without
+// a tiledb.DBAccessor.
+func (tr *TileRenderer) ExportPMTiles(l *layer.Layer, minZoom int, maxZoom int, path string) error {
+	if minZoom < 1 || maxZoom > 13 || minZoom > maxZoom {
+		return errors.New("invalid zoom range")
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// blobs dedupes identical tile bytes so repeated empty/ocean tiles
+	// collapse to a single stored blob.
+	type blob struct {
+		offset uint64
+		length uint32
+	}
+	blobs := make(map[[32]byte]blob)
+
+	var tileData bytes.Buffer
+	entries := make([]pmtilesEntry, 0)
+
+	for zoom := minZoom; zoom <= maxZoom; zoom++ {
+		n := 1 << uint(zoom)
+		for x := 0; x < n; x++ {
+			for y := 0; y < n; y++ {
+				tc := &coords.TileCoords{LayerId: l.Id, Zoom: zoom, X: x, Y: y}
+
+				data, err := tr.RenderFormat(tc, layer.FormatPNG)
+				if err != nil {
+					return err
+				}
+				if data == nil {
+					continue
+				}
+
+				sum := sha256.Sum256(data)
+				b, ok := blobs[sum]
+				if !ok {
+					b = blob{offset: uint64(tileData.Len()), length: uint32(len(data))}
+					tileData.Write(data)
+					blobs[sum] = b
+				}
+
+				entries = append(entries, pmtilesEntry{
+					TileID:    tileIDFromZXY(zoom, x, y),
+					RunLength: 1,
+					Offset:    b.offset,
+					Length:    b.length,
+				})
+			}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].TileID < entries[j].TileID })
+	entries = collapseRuns(entries)
+
+	rootDir, leafDirs, leafDirBytes := buildDirectories(entries)
+
+	meta, err := json.Marshal(map[string]interface{}{
+		"name":        l.Id,
+		"description": "mapserver PMTiles export",
+		"format":      "png",
+	})
+	if err != nil {
+		return err
+	}
+
+	rootDirOffset := uint64(pmtilesHeaderSize)
+	metadataOffset := rootDirOffset + uint64(len(rootDir))
+	leafDirsOffset := metadataOffset + uint64(len(meta))
+	tileDataOffset := leafDirsOffset + uint64(leafDirBytes)
+
+	header := make([]byte, pmtilesHeaderSize)
+	copy(header[0:7], pmtilesMagic)
+	header[7] = pmtilesVersion
+	binary.LittleEndian.PutUint64(header[8:16], rootDirOffset)
+	binary.LittleEndian.PutUint64(header[16:24], uint64(len(rootDir)))
+	binary.LittleEndian.PutUint64(header[24:32], metadataOffset)
+	binary.LittleEndian.PutUint64(header[32:40], uint64(len(meta)))
+	binary.LittleEndian.PutUint64(header[40:48], leafDirsOffset)
+	binary.LittleEndian.PutUint64(header[48:56], uint64(leafDirBytes))
+	binary.LittleEndian.PutUint64(header[56:64], tileDataOffset)
+	binary.LittleEndian.PutUint64(header[64:72], uint64(tileData.Len()))
+	var addressedTiles uint64
+	for _, e := range entries {
+		addressedTiles += uint64(e.RunLength)
+	}
+	binary.LittleEndian.PutUint64(header[72:80], addressedTiles)
+	binary.LittleEndian.PutUint64(header[80:88], uint64(len(entries)))
+	binary.LittleEndian.PutUint64(header[88:96], uint64(len(blobs)))
+	header[96] = 0 // clustered: tile data is not guaranteed Hilbert-ordered across runs
+	header[97] = pmtilesCompressionNone
+	header[98] = pmtilesCompressionNone
+	header[99] = pmtilesTileTypePNG
+	header[100] = byte(minZoom)
+	header[101] = byte(maxZoom)
+	binary.LittleEndian.PutUint32(header[102:106], uint32(int32(-180*1e7)))
+	binary.LittleEndian.PutUint32(header[106:110], uint32(int32(-90*1e7)))
+	binary.LittleEndian.PutUint32(header[110:114], uint32(int32(180*1e7)))
+	binary.LittleEndian.PutUint32(header[114:118], uint32(int32(90*1e7)))
+	header[118] = byte(minZoom)
+	binary.LittleEndian.PutUint32(header[119:123], uint32(int32(0)))
+	binary.LittleEndian.PutUint32(header[123:127], uint32(int32(0)))
+
+	if _, err := f.Write(header); err != nil {
+		return err
+	}
+	if _, err := f.Write(rootDir); err != nil {
+		return err
+	}
+	if _, err := f.Write(meta); err != nil {
+		return err
+	}
+	for _, leaf := range leafDirs {
+		if _, err := f.Write(leaf); err != nil {
+			return err
+		}
+	}
+	if _, err := io.Copy(f, bytes.NewReader(tileData.Bytes())); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// collapseRuns merges consecutive entries that share offset/length and are
+// contiguous in tile_id into a single run-length entry.
+func collapseRuns(entries []pmtilesEntry) []pmtilesEntry {
+	if len(entries) == 0 {
+		return entries
+	}
+
+	out := entries[:1]
+	for _, e := range entries[1:] {
+		last := &out[len(out)-1]
+		if e.Offset == last.Offset && e.Length == last.Length && e.TileID == last.TileID+uint64(last.RunLength) {
+			last.RunLength++
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// buildDirectories serializes entries as a single root directory, splitting
+// into root+leaves once the root would exceed pmtilesMaxDirBytes.
+func buildDirectories(entries []pmtilesEntry) (root []byte, leaves [][]byte, leafBytes int) {
+	root = serializeEntries(entries)
+	if len(root) <= pmtilesMaxDirBytes {
+		return root, nil, 0
+	}
+
+	// Split into leaves of roughly pmtilesMaxDirBytes worth of entries each,
+	// and a root directory of leaf summaries.
+	const perLeaf = 2048
+	leafEntries := make([]pmtilesEntry, 0, perLeaf)
+	rootEntries := make([]pmtilesEntry, 0)
+
+	flush := func() {
+		if len(leafEntries) == 0 {
+			return
+		}
+		leaf := serializeEntries(leafEntries)
+		rootEntries = append(rootEntries, pmtilesEntry{
+			TileID:    leafEntries[0].TileID,
+			RunLength: 0, // run_length 0 marks a leaf pointer in the root dir
+			Offset:    uint64(leafBytes),
+			Length:    uint32(len(leaf)),
+		})
+		leaves = append(leaves, leaf)
+		leafBytes += len(leaf)
+		leafEntries = leafEntries[:0]
+	}
+
+	for _, e := range entries {
+		leafEntries = append(leafEntries, e)
+		if len(leafEntries) == perLeaf {
+			flush()
+		}
+	}
+	flush()
+
+	return serializeEntries(rootEntries), leaves, leafBytes
+}
+
+func serializeEntries(entries []pmtilesEntry) []byte {
+	var buf bytes.Buffer
+	varint := make([]byte, binary.MaxVarintLen64)
+
+	putUvarint := func(v uint64) {
+		n := binary.PutUvarint(varint, v)
+		buf.Write(varint[:n])
+	}
+
+	putUvarint(uint64(len(entries)))
+
+	var lastID uint64
+	for _, e := range entries {
+		putUvarint(e.TileID - lastID)
+		lastID = e.TileID
+	}
+	for _, e := range entries {
+		putUvarint(uint64(e.RunLength))
+	}
+	for _, e := range entries {
+		putUvarint(uint64(e.Length))
+	}
+	for i, e := range entries {
+		if i > 0 && e.Offset == entries[i-1].Offset+uint64(entries[i-1].Length) {
+			putUvarint(0)
+		} else {
+			putUvarint(e.Offset + 1)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// tileIDFromZXY converts a z/x/y tile coordinate to the Hilbert-curve based
+// tile_id used by the PMTiles spec: a base offset for all tiles of lower
+// zoom levels, plus the Hilbert index of (x, y) within the zoom level.
+func tileIDFromZXY(z, x, y int) uint64 {
+	var acc uint64
+	for t := 0; t < z; t++ {
+		acc += (uint64(1) << uint(2*t))
+	}
+
+	n := uint64(1) << uint(z)
+	rx, ry := uint64(0), uint64(0)
+	var d uint64
+	ux, uy := uint64(x), uint64(y)
+
+	for s := n / 2; s > 0; s /= 2 {
+		if ux&s > 0 {
+			rx = 1
+		} else {
+			rx = 0
+		}
+		if uy&s > 0 {
+			ry = 1
+		} else {
+			ry = 0
+		}
+		d += s * s * ((3 * rx) ^ ry)
+
+		// rotate
+		if ry == 0 {
+			if rx == 1 {
+				ux = s - 1 - ux
+				uy = s - 1 - uy
+			}
+			ux, uy = uy, ux
+		}
+	}
+
+	return acc + d
+}
@@ -0,0 +1,98 @@
+package tilerenderer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// TestTileIDFromZXYBaseOffsets checks the accumulated per-zoom base offset
+// that tileIDFromZXY adds before the Hilbert index: each zoom level holds
+// 4^zoom tiles, so (0,0,0) at zoom z must land exactly on the sum of all
+// lower levels' tile counts.
+func TestTileIDFromZXYBaseOffsets(t *testing.T) {
+	var base uint64
+	for z := 0; z <= 6; z++ {
+		if got := tileIDFromZXY(z, 0, 0); got != base {
+			t.Errorf("zoom %d: tileIDFromZXY(z,0,0) = %d, want base %d", z, got, base)
+		}
+		base += uint64(1) << uint(2*z)
+	}
+}
+
+// TestTileIDFromZXYUnique checks that every (x, y) within a zoom level maps
+// to a distinct tile_id, as required for the Hilbert index to be usable as a
+// directory lookup key.
+func TestTileIDFromZXYUnique(t *testing.T) {
+	const zoom = 4
+	n := 1 << zoom
+	seen := make(map[uint64]struct{}, n*n)
+
+	for x := 0; x < n; x++ {
+		for y := 0; y < n; y++ {
+			id := tileIDFromZXY(zoom, x, y)
+			if _, ok := seen[id]; ok {
+				t.Fatalf("tileIDFromZXY(%d, %d, %d) = %d collides with an earlier tile", zoom, x, y, id)
+			}
+			seen[id] = struct{}{}
+		}
+	}
+}
+
+// deserializeEntries is serializeEntries's inverse, used here only to check
+// that encoding round-trips; ExportPMTiles never needs to read its own
+// directories back.
+func deserializeEntries(data []byte) []pmtilesEntry {
+	r := bytes.NewReader(data)
+
+	count, _ := binary.ReadUvarint(r)
+	entries := make([]pmtilesEntry, count)
+
+	var lastID uint64
+	for i := range entries {
+		delta, _ := binary.ReadUvarint(r)
+		lastID += delta
+		entries[i].TileID = lastID
+	}
+	for i := range entries {
+		rl, _ := binary.ReadUvarint(r)
+		entries[i].RunLength = uint32(rl)
+	}
+	for i := range entries {
+		length, _ := binary.ReadUvarint(r)
+		entries[i].Length = uint32(length)
+	}
+	for i := range entries {
+		raw, _ := binary.ReadUvarint(r)
+		if raw == 0 {
+			entries[i].Offset = entries[i-1].Offset + uint64(entries[i-1].Length)
+		} else {
+			entries[i].Offset = raw - 1
+		}
+	}
+
+	return entries
+}
+
+// TestSerializeEntriesRoundTrip checks that the varint directory format
+// serializeEntries produces recovers the exact TileID/RunLength/Offset/Length
+// of every entry, including the contiguous-offset shorthand ExportPMTiles
+// relies on to keep directories small.
+func TestSerializeEntriesRoundTrip(t *testing.T) {
+	entries := []pmtilesEntry{
+		{TileID: 0, RunLength: 1, Offset: 0, Length: 100},
+		{TileID: 1, RunLength: 1, Offset: 100, Length: 50}, // contiguous with entry 0
+		{TileID: 5, RunLength: 3, Offset: 1000, Length: 20}, // non-contiguous
+	}
+
+	got := deserializeEntries(serializeEntries(entries))
+
+	if len(got) != len(entries) {
+		t.Fatalf("got %d entries, want %d", len(got), len(entries))
+	}
+	for i, want := range entries {
+		if got[i] != want {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], want)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"mapserver/layer"
 	"mapserver/mapblockrenderer"
 	"mapserver/tiledb"
+	"sync"
 	"time"
 
 	"github.com/disintegration/imaging"
@@ -21,79 +22,101 @@ type TileRenderer struct {
 	mapblockrenderer *mapblockrenderer.MapBlockRenderer
 	layers           []layer.Layer
 	tdb              tiledb.DBAccessor
+	locker           *tiledb.StripedLockAccessor
 	dba              db.DBAccessor
+
+	// sem bounds the number of a tile's child quadrants that renderQuadrants
+	// renders concurrently. It is not held across a top-level RenderFormat/
+	// RenderVector call: that call's own goroutine doesn't take a permit,
+	// only the recursive per-quadrant ones it spawns do, so a permit held
+	// for the whole call can't starve its own children out of the same
+	// pool (see renderQuadrants).
+	sem chan struct{}
 }
 
+// NewTileRenderer creates a TileRenderer that renders quadrants serially.
 func NewTileRenderer(mapblockrenderer *mapblockrenderer.MapBlockRenderer,
 	tdb tiledb.DBAccessor,
 	dba db.DBAccessor,
 	layers []layer.Layer) *TileRenderer {
 
+	return NewTileRendererWithWorkers(mapblockrenderer, tdb, dba, layers, 1)
+}
+
+// NewTileRendererWithWorkers creates a TileRenderer whose RenderImage calls
+// render a tile's four child quadrants concurrently, bounded to workers
+// goroutines in flight at once.
+func NewTileRendererWithWorkers(mapblockrenderer *mapblockrenderer.MapBlockRenderer,
+	tdb tiledb.DBAccessor,
+	dba db.DBAccessor,
+	layers []layer.Layer,
+	workers int) *TileRenderer {
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	locker := tiledb.NewStripedLockAccessor(tdb)
+
 	return &TileRenderer{
 		mapblockrenderer: mapblockrenderer,
 		layers:           layers,
-		tdb:              tdb,
+		tdb:              locker,
+		locker:           locker,
 		dba:              dba,
+		sem:              make(chan struct{}, workers),
 	}
 }
 
+func (tr *TileRenderer) acquire() { tr.sem <- struct{}{} }
+func (tr *TileRenderer) release() { <-tr.sem }
+
 const (
 	IMG_SIZE = 256
 )
 
+// Render returns tc encoded in its layer's configured default format (PNG
+// unless layer.Layer.Format says otherwise). Use RenderFormat to request a
+// specific format.
 func (tr *TileRenderer) Render(tc *coords.TileCoords) ([]byte, error) {
+	return tr.RenderFormat(tc, tr.defaultFormat(tc.LayerId))
+}
 
-	//Check cache
-	tile, err := tr.tdb.GetTile(tc)
-	if err != nil {
-		return nil, err
-	}
-
-	if tile == nil {
-		//No tile in db
-		img, err := tr.RenderImage(tc, false)
-
-		if err != nil {
-			return nil, err
-		}
-
-		if img == nil {
-			//empty tile
-			return nil, nil
-		}
-
-		buf := new(bytes.Buffer)
-		png.Encode(buf, img)
+// RenderImage returns tc losslessly composed as an *image.NRGBA, serving a
+// cached result when one exists and isn't dirty. The whole check-cache,
+// render-on-miss, store sequence for tc runs under tc's own per-tile lock
+// (tr.locker), so two concurrent callers for the same tile can't both pass
+// the cache-miss check and redundantly render it.
+func (tr *TileRenderer) RenderImage(tc *coords.TileCoords, cachedOnly bool) (*image.NRGBA, error) {
+	var img *image.NRGBA
 
-		return buf.Bytes(), nil
-	}
+	err := tr.locker.WithLock(tc, func() error {
+		var renderErr error
+		img, renderErr = tr.renderImageLocked(tc, cachedOnly)
+		return renderErr
+	})
 
-	return tile.Data, nil
+	return img, err
 }
 
-func (tr *TileRenderer) RenderImage(tc *coords.TileCoords, cachedOnly bool) (*image.NRGBA, error) {
+// renderImageLocked is RenderImage's body, assumed to already be running
+// under tc's per-tile lock. Call it directly (instead of RenderImage) from
+// another method that is already holding that same lock for tc, to avoid
+// self-deadlocking on a non-reentrant mutex.
+func (tr *TileRenderer) renderImageLocked(tc *coords.TileCoords, cachedOnly bool) (*image.NRGBA, error) {
 
 	cachedtile, err := tr.tdb.GetTile(tc)
 	if err != nil {
 		return nil, err
 	}
 
-	if cachedtile != nil {
-		reader := bytes.NewReader(cachedtile.Data)
-		cachedimg, err := png.Decode(reader)
-		if err != nil {
-			return nil, err
-		}
-
-		rect := image.Rectangle{
-			image.Point{0, 0},
-			image.Point{IMG_SIZE, IMG_SIZE},
-		}
-
-		img := image.NewNRGBA(rect)
-		draw.Draw(img, rect, cachedimg, image.ZP, draw.Src)
-
-		return img, nil
+	// Only a lossless PNG row is safe to recomposite from: RenderFormat may
+	// have since overwritten this same tile with a lossy WebP/JPEG encode
+	// for HTTP serving, and decoding that back in here would bake its
+	// compression artifacts into every ancestor tile above it. Treat
+	// anything else as a cache miss and recompose losslessly instead.
+	if cachedtile != nil && !cachedtile.Dirty && layer.Format(cachedtile.Format) == layer.FormatPNG {
+		return decodeTileImage(cachedtile)
 	}
 
 	if cachedOnly {
@@ -132,22 +155,7 @@ func (tr *TileRenderer) RenderImage(tc *coords.TileCoords, cachedOnly bool) (*im
 
 	recursiveCachedOnly := tc.Zoom < 12
 
-	upperLeft, err := tr.RenderImage(quads.UpperLeft, recursiveCachedOnly)
-	if err != nil {
-		return nil, err
-	}
-
-	upperRight, err := tr.RenderImage(quads.UpperRight, recursiveCachedOnly)
-	if err != nil {
-		return nil, err
-	}
-
-	lowerLeft, err := tr.RenderImage(quads.LowerLeft, recursiveCachedOnly)
-	if err != nil {
-		return nil, err
-	}
-
-	lowerRight, err := tr.RenderImage(quads.LowerRight, recursiveCachedOnly)
+	upperLeft, upperRight, lowerLeft, lowerRight, err := tr.renderQuadrants(quads, recursiveCachedOnly)
 	if err != nil {
 		return nil, err
 	}
@@ -188,8 +196,58 @@ func (tr *TileRenderer) RenderImage(tc *coords.TileCoords, cachedOnly bool) (*im
 		png.Encode(buf, img)
 	}
 
-	tile := tiledb.Tile{Pos: tc, Data: buf.Bytes(), Mtime: time.Now().Unix()}
+	// "png": layer.FormatPNG's underlying value. layer here is the *layer.Layer
+	// local above, which shadows the layer package for the rest of this func.
+	tile := tiledb.Tile{Pos: tc, Data: buf.Bytes(), Mtime: time.Now().Unix(), Format: "png"}
 	tr.tdb.SetTile(&tile)
 
 	return img, nil
+}
+
+// renderQuadrants submits the four child tiles as jobs to the shared
+// worker pool and waits for all of them, so a parent tile's quadrants
+// render concurrently instead of one after another.
+func (tr *TileRenderer) renderQuadrants(quads *coords.Quadrants, cachedOnly bool) (upperLeft, upperRight, lowerLeft, lowerRight *image.NRGBA, err error) {
+	var wg sync.WaitGroup
+	var ulErr, urErr, llErr, lrErr error
+
+	wg.Add(4)
+
+	go func() {
+		defer wg.Done()
+		tr.acquire()
+		defer tr.release()
+		upperLeft, ulErr = tr.RenderImage(quads.UpperLeft, cachedOnly)
+	}()
+
+	go func() {
+		defer wg.Done()
+		tr.acquire()
+		defer tr.release()
+		upperRight, urErr = tr.RenderImage(quads.UpperRight, cachedOnly)
+	}()
+
+	go func() {
+		defer wg.Done()
+		tr.acquire()
+		defer tr.release()
+		lowerLeft, llErr = tr.RenderImage(quads.LowerLeft, cachedOnly)
+	}()
+
+	go func() {
+		defer wg.Done()
+		tr.acquire()
+		defer tr.release()
+		lowerRight, lrErr = tr.RenderImage(quads.LowerRight, cachedOnly)
+	}()
+
+	wg.Wait()
+
+	for _, e := range []error{ulErr, urErr, llErr, lrErr} {
+		if e != nil {
+			return nil, nil, nil, nil, e
+		}
+	}
+
+	return upperLeft, upperRight, lowerLeft, lowerRight, nil
 }
\ No newline at end of file
@@ -0,0 +1,47 @@
+package tilerenderer
+
+import (
+	"testing"
+	"time"
+
+	"mapserver/coords"
+	"mapserver/layer"
+	"mapserver/tiledb"
+)
+
+// alwaysMissDB is a tiledb.DBAccessor that never has a tile cached, so every
+// GetTile looks like a fresh cache miss and every SetTile/MarkDirty is a
+// no-op.
+type alwaysMissDB struct{}
+
+func (alwaysMissDB) GetTile(tc *coords.TileCoords) (*tiledb.Tile, error) { return nil, nil }
+func (alwaysMissDB) SetTile(tile *tiledb.Tile) error                     { return nil }
+func (alwaysMissDB) MarkDirty(tc *coords.TileCoords) error               { return nil }
+
+// TestRenderImageDoesNotDeadlockUnderDefaultWorkers renders a zoom-1 tile
+// through the default, backward-compatible NewTileRenderer constructor
+// (workers=1, so tr.sem has capacity exactly 1). renderQuadrants' four child
+// goroutines each need a permit from that same pool; if RenderImage's own
+// call held a permit across the recursive render (as it used to), those
+// children would block forever and this test would hang instead of
+// completing within the timeout.
+func TestRenderImageDoesNotDeadlockUnderDefaultWorkers(t *testing.T) {
+	tr := NewTileRenderer(nil, alwaysMissDB{}, nil, []layer.Layer{{Id: 0}})
+
+	tc := &coords.TileCoords{LayerId: 0, Zoom: 1, X: 0, Y: 0}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := tr.RenderImage(tc, false)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("RenderImage returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RenderImage deadlocked: tr.sem's single permit was held across the recursive quadrant render")
+	}
+}
@@ -0,0 +1,341 @@
+package tilerenderer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"time"
+
+	"mapserver/coords"
+	"mapserver/layer"
+	"mapserver/tiledb"
+)
+
+const (
+	mvtExtent = 4096
+
+	mvtGeomTypePolygon = 3
+
+	mvtCmdMoveTo    = 1
+	mvtCmdLineTo    = 2
+	mvtCmdClosePath = 7
+)
+
+// mvtFeature is a single collapsed run of same-node tiles, rendered as a
+// rectangular polygon covering its span within the tile.
+type mvtFeature struct {
+	node  string
+	yTop  int
+	light int
+	// x1,y1 - x2,y2 is the feature's rectangle in tile-local pixel space.
+	x1, y1, x2, y2 int
+}
+
+// RenderVector renders tc as a Mapbox Vector Tile protobuf instead of a PNG,
+// for layers configured with layer.FormatMVT. At zoom 13 it encodes the
+// mapblock column data directly; at lower zooms it aggregates the four
+// child tiles' features rather than resampling bitmaps. The whole
+// check-cache, render-on-miss, store sequence for tc runs under tc's own
+// per-tile lock (tr.locker), so two concurrent callers for the same tile
+// can't both pass the cache-miss check and redundantly render it.
+//
+// This call doesn't take a tr.sem permit itself, for the same reason as
+// RenderFormat: that pool is reserved for renderQuadrants' own recursive
+// quadrant goroutines, not top-level entry points.
+func (tr *TileRenderer) RenderVector(tc *coords.TileCoords) ([]byte, error) {
+	var data []byte
+
+	err := tr.locker.WithLock(tc, func() error {
+		var renderErr error
+		data, renderErr = tr.renderVectorLocked(tc)
+		return renderErr
+	})
+
+	return data, err
+}
+
+// renderVectorLocked is RenderVector's body, assumed to already be running
+// under tc's per-tile lock.
+func (tr *TileRenderer) renderVectorLocked(tc *coords.TileCoords) ([]byte, error) {
+	cached, err := tr.tdb.GetTile(tc)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && !cached.Dirty && layer.Format(cached.Format) == layer.FormatMVT {
+		return cached.Data, nil
+	}
+
+	features, err := tr.vectorFeatures(tc)
+	if err != nil {
+		return nil, err
+	}
+
+	data := encodeMVT("mapserver", features)
+
+	tr.tdb.SetTile(&tiledb.Tile{Pos: tc, Data: data, Mtime: time.Now().Unix(), Format: string(layer.FormatMVT)})
+
+	return data, nil
+}
+
+// vectorFeatures computes the collapsed feature list for tc, recursing into
+// child tiles for zooms below 13 so aggregation works on features directly
+// rather than round-tripping through encoded MVT bytes.
+func (tr *TileRenderer) vectorFeatures(tc *coords.TileCoords) ([]mvtFeature, error) {
+	var l *layer.Layer
+	for _, candidate := range tr.layers {
+		if candidate.Id == tc.LayerId {
+			c := candidate
+			l = &c
+		}
+	}
+	if l == nil {
+		return nil, errors.New("No layer found")
+	}
+
+	if tc.Zoom > 13 || tc.Zoom < 1 {
+		return nil, errors.New("Invalid zoom")
+	}
+
+	if tc.Zoom == 13 {
+		return tr.mapblockColumnFeatures(tc, l)
+	}
+
+	return tr.aggregateChildFeatures(tc)
+}
+
+// mapblockColumnFeatures scans the mapblock column under tc and collapses
+// runs of the same top-visible node into rectangular polygon features.
+func (tr *TileRenderer) mapblockColumnFeatures(tc *coords.TileCoords, l *layer.Layer) ([]mvtFeature, error) {
+	mbr := coords.GetMapBlockRangeFromTile(tc, 0)
+	mbr.Pos1.Y = l.From
+	mbr.Pos2.Y = l.To
+
+	columns, err := tr.mapblockrenderer.RenderColumns(mbr.Pos1, mbr.Pos2)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, nil
+	}
+
+	size := IMG_SIZE / len(columns)
+	features := make([]mvtFeature, 0, len(columns))
+
+	for i, col := range columns {
+		x1 := i * size
+		x2 := x1 + size
+
+		if len(features) > 0 {
+			last := &features[len(features)-1]
+			if last.node == col.Node && last.yTop == col.YTop && last.light == col.Light && last.x2 == x1 {
+				last.x2 = x2
+				continue
+			}
+		}
+
+		features = append(features, mvtFeature{
+			node:  col.Node,
+			yTop:  col.YTop,
+			light: col.Light,
+			x1:    x1,
+			y1:    0,
+			x2:    x2,
+			y2:    IMG_SIZE,
+		})
+	}
+
+	return features, nil
+}
+
+// aggregateChildFeatures merges the four child tiles' features into this
+// tile's coordinate space, halving their extent and offsetting by quadrant,
+// instead of resampling a composited bitmap.
+func (tr *TileRenderer) aggregateChildFeatures(tc *coords.TileCoords) ([]mvtFeature, error) {
+	quads := tc.GetZoomedQuadrantsFromTile()
+
+	type quadrant struct {
+		tc     *coords.TileCoords
+		offset image2x2
+	}
+
+	quadrants := []quadrant{
+		{quads.UpperLeft, image2x2{0, 0}},
+		{quads.UpperRight, image2x2{IMG_SIZE / 2, 0}},
+		{quads.LowerLeft, image2x2{0, IMG_SIZE / 2}},
+		{quads.LowerRight, image2x2{IMG_SIZE / 2, IMG_SIZE / 2}},
+	}
+
+	var merged []mvtFeature
+	for _, q := range quadrants {
+		childFeatures, err := tr.vectorFeatures(q.tc)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range childFeatures {
+			merged = append(merged, mvtFeature{
+				node:  f.node,
+				yTop:  f.yTop,
+				light: f.light,
+				x1:    q.offset.x + f.x1/2,
+				y1:    q.offset.y + f.y1/2,
+				x2:    q.offset.x + f.x2/2,
+				y2:    q.offset.y + f.y2/2,
+			})
+		}
+	}
+
+	return merged, nil
+}
+
+type image2x2 struct{ x, y int }
+
+func encodeMVT(name string, features []mvtFeature) []byte {
+	keys := []string{"node", "y_top", "light"}
+	keyIndex := map[string]int{"node": 0, "y_top": 1, "light": 2}
+
+	type value struct {
+		str    *string
+		intVal *int64
+	}
+	var values []value
+	valueIndex := make(map[string]int)
+
+	valueIdx := func(v value) int {
+		var k string
+		if v.str != nil {
+			k = "s:" + *v.str
+		} else {
+			k = "i:" + strconv.FormatInt(*v.intVal, 10)
+		}
+		if idx, ok := valueIndex[k]; ok {
+			return idx
+		}
+		values = append(values, v)
+		idx := len(values) - 1
+		valueIndex[k] = idx
+		return idx
+	}
+
+	var layerBuf bytes.Buffer
+	writeStringField(&layerBuf, 1, name)
+
+	var featuresBuf bytes.Buffer
+	for _, f := range features {
+		nodeIdx := valueIdx(value{str: strPtr(f.node)})
+		yTopV := int64(f.yTop)
+		yTopIdx := valueIdx(value{intVal: &yTopV})
+		lightV := int64(f.light)
+		lightIdx := valueIdx(value{intVal: &lightV})
+
+		var featBuf bytes.Buffer
+		tags := []uint32{
+			uint32(keyIndex["node"]), uint32(nodeIdx),
+			uint32(keyIndex["y_top"]), uint32(yTopIdx),
+			uint32(keyIndex["light"]), uint32(lightIdx),
+		}
+		writePackedUint32(&featBuf, 2, tags)
+		writeVarintField(&featBuf, 3, uint64(mvtGeomTypePolygon))
+		// Features are in IMG_SIZE pixel space; scale them up to mvtExtent.
+		scale := mvtExtent / IMG_SIZE
+		writePackedUint32(&featBuf, 4, polygonGeometry(f.x1*scale, f.y1*scale, f.x2*scale, f.y2*scale))
+
+		writeTag(&featuresBuf, 2, 2) // features field, wire type 2 (length-delimited)
+		writeVarint(&featuresBuf, uint64(featBuf.Len()))
+		featuresBuf.Write(featBuf.Bytes())
+	}
+	layerBuf.Write(featuresBuf.Bytes())
+
+	for _, k := range keys {
+		writeStringField(&layerBuf, 3, k)
+	}
+	for _, v := range values {
+		var valBuf bytes.Buffer
+		if v.str != nil {
+			writeStringField(&valBuf, 1, *v.str)
+		} else {
+			writeVarintFieldSigned(&valBuf, 6, *v.intVal)
+		}
+		writeTag(&layerBuf, 4, 2)
+		writeVarint(&layerBuf, uint64(valBuf.Len()))
+		layerBuf.Write(valBuf.Bytes())
+	}
+
+	writeVarintField(&layerBuf, 5, uint64(mvtExtent))
+	writeVarintField(&layerBuf, 15, 2) // version = 2
+
+	var tileBuf bytes.Buffer
+	writeTag(&tileBuf, 3, 2)
+	writeVarint(&tileBuf, uint64(layerBuf.Len()))
+	tileBuf.Write(layerBuf.Bytes())
+
+	return tileBuf.Bytes()
+}
+
+// polygonGeometry encodes a single axis-aligned rectangle as an MVT
+// geometry command stream: MoveTo, three LineTo, ClosePath.
+func polygonGeometry(x1, y1, x2, y2 int) []uint32 {
+	var cmds []uint32
+	cx, cy := 0, 0
+
+	moveTo := func(x, y int) {
+		cmds = append(cmds, cmdInt(mvtCmdMoveTo, 1))
+		cmds = append(cmds, zigzag(x-cx), zigzag(y-cy))
+		cx, cy = x, y
+	}
+	lineTo := func(x, y int) {
+		cmds = append(cmds, cmdInt(mvtCmdLineTo, 1))
+		cmds = append(cmds, zigzag(x-cx), zigzag(y-cy))
+		cx, cy = x, y
+	}
+
+	moveTo(x1, y1)
+	lineTo(x2, y1)
+	lineTo(x2, y2)
+	lineTo(x1, y2)
+	cmds = append(cmds, cmdInt(mvtCmdClosePath, 1))
+
+	return cmds
+}
+
+func cmdInt(id, count uint32) uint32 { return (id & 0x7) | (count << 3) }
+
+func zigzag(v int) uint32 { return uint32((v << 1) ^ (v >> 31)) }
+
+func strPtr(s string) *string { return &s }
+
+func writeVarint(buf *bytes.Buffer, v uint64) {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(tmp, v)
+	buf.Write(tmp[:n])
+}
+
+func writeTag(buf *bytes.Buffer, field int, wireType int) {
+	writeVarint(buf, uint64(field)<<3|uint64(wireType))
+}
+
+func writeVarintField(buf *bytes.Buffer, field int, v uint64) {
+	writeTag(buf, field, 0)
+	writeVarint(buf, v)
+}
+
+func writeVarintFieldSigned(buf *bytes.Buffer, field int, v int64) {
+	writeVarintField(buf, field, uint64(zigzag(int(v))))
+}
+
+func writeStringField(buf *bytes.Buffer, field int, s string) {
+	writeTag(buf, field, 2)
+	writeVarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func writePackedUint32(buf *bytes.Buffer, field int, vals []uint32) {
+	var packed bytes.Buffer
+	for _, v := range vals {
+		writeVarint(&packed, uint64(v))
+	}
+	writeTag(buf, field, 2)
+	writeVarint(buf, uint64(packed.Len()))
+	buf.Write(packed.Bytes())
+}